@@ -0,0 +1,191 @@
+package geominder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBatchSize bounds a POST /bulk request's IP list when
+// HTTPHandler.MaxBatchSize is unset.
+const DefaultMaxBatchSize = 100
+
+// BulkResult is one element of the POST /bulk response array, in the same
+// order as the request's IP list. Exactly one of Result or Error is set.
+type BulkResult struct {
+	IP     string        `json:"ip"`
+	Result *LookupResult `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// lookupResultPool recycles LookupResult values across POST /bulk requests
+// so FastLookup doesn't allocate one per IP.
+var lookupResultPool = sync.Pool{
+	New: func() interface{} { return new(LookupResult) },
+}
+
+// fastLookuper is implemented by LookupDB; serveBulk prefers it to avoid
+// the extra allocation Lookup makes, falling back to plain Lookup for a
+// Lookuper (e.g. MultiDB) that doesn't implement it.
+type fastLookuper interface {
+	FastLookup(ip net.IP, r *LookupResult) error
+}
+
+// serveBulk implements POST /bulk (or /lookup): decode a JSON array of IP
+// strings, bounded by MaxBatchSize, look each one up with a worker pool
+// sized to GOMAXPROCS, and stream the response array back one BulkResult at
+// a time, in request order, as each one becomes ready -- rather than
+// buffering the whole batch before writing anything.
+func (hh *HTTPHandler) serveBulk(w http.ResponseWriter, r *http.Request) {
+	batchStart := time.Now()
+	status := http.StatusOK
+	defer func() {
+		hh.logRequest(r, status, time.Since(batchStart), false)
+	}()
+	w.Header().Set("Content-Type", "application/json")
+
+	var ips []string
+	if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+		status = http.StatusBadRequest
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error": "request body must be a JSON array of IP strings"}`))
+		return
+	}
+
+	maxBatch := hh.MaxBatchSize
+	if maxBatch == 0 {
+		maxBatch = DefaultMaxBatchSize
+	}
+	if len(ips) > maxBatch {
+		status = http.StatusBadRequest
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": "batch of %d IPs exceeds MaxBatchSize of %d"}`, len(ips), maxBatch)
+		return
+	}
+
+	// One buffered slot per IP: a worker can hand off its result and move on
+	// to the next job without waiting for the response writer to catch up.
+	done := make([]chan BulkResult, len(ips))
+	for i := range done {
+		done[i] = make(chan BulkResult, 1)
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(ips) {
+		workers = len(ips)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for idx := range ips {
+			jobs <- idx
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for idx := range jobs {
+				done[idx] <- hh.bulkLookupOne(ips[idx])
+			}
+		}()
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	w.Write([]byte("["))
+	for i, ch := range done {
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		_ = enc.Encode(<-ch) // array elements arrive in order; Encode's own newline is harmless JSON whitespace
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	w.Write([]byte("]"))
+}
+
+// bulkLookupOne resolves a single IP from a POST /bulk batch, reusing
+// MemCache the same way ServeHTTP does for a single "en"/json lookup,
+// recording the same Metrics/Logger observability ServeHTTP does, and
+// otherwise decoding via a pooled LookupResult and FastLookup.
+func (hh *HTTPHandler) bulkLookupOne(ipText string) BulkResult {
+	res := BulkResult{IP: ipText}
+
+	cacheKey := ipText + "|en|json"
+	if hh.MemCache != nil {
+		if cached, err := hh.MemCache.Get(cacheKey); err == nil {
+			var cachedResult LookupResult
+			if err := json.Unmarshal(cached, &cachedResult); err == nil {
+				hh.recordCache(true)
+				res.Result = &cachedResult
+				hh.loggerLog(ipText, http.StatusOK, 0, true)
+				return res
+			}
+		}
+	}
+	hh.recordCache(false)
+
+	ip := net.ParseIP(ipText)
+	if ip == nil {
+		res.Error = "could not parse invalid IP address"
+		hh.loggerLog(ipText, http.StatusBadRequest, 0, false)
+		return res
+	}
+
+	r2 := lookupResultPool.Get().(*LookupResult)
+	*r2 = LookupResult{}
+	defer lookupResultPool.Put(r2)
+
+	lookupStart := time.Now()
+	var err error
+	if fl, ok := hh.DB.(fastLookuper); ok {
+		err = fl.FastLookup(ip, r2)
+	} else {
+		var looked *LookupResult
+		looked, err = hh.DB.Lookup(ip)
+		if err == nil {
+			*r2 = *looked
+		}
+	}
+	latency := time.Since(lookupStart)
+	hh.recordLookup(latency, err)
+	if err != nil {
+		res.Error = err.Error()
+		hh.loggerLog(ipText, http.StatusInternalServerError, latency, false)
+		return res
+	}
+	r2.Localize("en")
+
+	out := *r2
+	res.Result = &out
+
+	if hh.MemCache != nil {
+		if b, err := json.Marshal(&out); err == nil {
+			hh.MemCache.Set(cacheKey, b)
+		}
+	}
+	hh.loggerLog(ipText, http.StatusOK, latency, false)
+	return res
+}
+
+// loggerLog emits a RequestLogEntry for a single bulk sub-lookup, if Logger
+// is set.
+func (hh *HTTPHandler) loggerLog(ip string, status int, latency time.Duration, cacheHit bool) {
+	if hh.Logger == nil {
+		return
+	}
+	hh.Logger.LogRequest(RequestLogEntry{
+		IP:       ip,
+		Status:   status,
+		Latency:  latency,
+		CacheHit: cacheHit,
+	})
+}
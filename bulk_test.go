@@ -0,0 +1,215 @@
+package geominder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bulkStubDB is a Lookuper (and fastLookuper) whose per-IP delay and
+// success/failure can be configured up front, so tests can control
+// completion ordering and exercise both the result and error response
+// shapes.
+type bulkStubDB struct {
+	mu     sync.Mutex
+	delays map[string]time.Duration
+	fail   map[string]bool
+}
+
+func (d *bulkStubDB) Lookup(ip net.IP) (*LookupResult, error) {
+	r := new(LookupResult)
+	if err := d.FastLookup(ip, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *bulkStubDB) FastLookup(ip net.IP, r *LookupResult) error {
+	ipStr := ip.String()
+	d.mu.Lock()
+	delay := d.delays[ipStr]
+	fail := d.fail[ipStr]
+	d.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fail {
+		return fmt.Errorf("simulated lookup failure for %s", ipStr)
+	}
+	r.Country = country{ISOCode: ipStr}
+	return nil
+}
+
+func decodeBulkResponse(t *testing.T, rec *httptest.ResponseRecorder) []BulkResult {
+	t.Helper()
+	var got []BulkResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding /bulk response %s: %v", rec.Body.String(), err)
+	}
+	return got
+}
+
+func postBulk(hh *HTTPHandler, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/bulk", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	hh.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestServeBulkPreservesOrderUnderConcurrency makes earlier IPs in the
+// batch take longer than later ones, so a naive "write as workers finish"
+// implementation would reorder the response; serveBulk streams results
+// back in request order regardless of completion order.
+func TestServeBulkPreservesOrderUnderConcurrency(t *testing.T) {
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5"}
+	db := &bulkStubDB{delays: map[string]time.Duration{
+		"1.1.1.1": 40 * time.Millisecond,
+		"2.2.2.2": 30 * time.Millisecond,
+		"3.3.3.3": 20 * time.Millisecond,
+		"4.4.4.4": 10 * time.Millisecond,
+		"5.5.5.5": 0,
+	}}
+	hh := NewHTTPHandler(db)
+	hh.DisableCache()
+
+	body, _ := json.Marshal(ips)
+	rec := postBulk(hh, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	got := decodeBulkResponse(t, rec)
+	if len(got) != len(ips) {
+		t.Fatalf("got %d results, want %d", len(got), len(ips))
+	}
+	for i, want := range ips {
+		if got[i].IP != want {
+			t.Fatalf("result[%d].IP = %q, want %q (response order must match request order)", i, got[i].IP, want)
+		}
+	}
+}
+
+// TestServeBulkPerItemErrorVsResultShape covers the three ways a single
+// batch element can resolve: an unparsable IP, a lookup-level failure, and
+// a success -- exactly one of Result/Error should be set in each case.
+func TestServeBulkPerItemErrorVsResultShape(t *testing.T) {
+	db := &bulkStubDB{fail: map[string]bool{"1.2.3.4": true}}
+	hh := NewHTTPHandler(db)
+	hh.DisableCache()
+
+	body, _ := json.Marshal([]string{"not-an-ip", "1.2.3.4", "5.6.7.8"})
+	rec := postBulk(hh, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	got := decodeBulkResponse(t, rec)
+	if got[0].Error == "" || got[0].Result != nil {
+		t.Errorf("result[0] (unparsable IP) = %+v, want Error set and Result nil", got[0])
+	}
+	if got[1].Error == "" || got[1].Result != nil {
+		t.Errorf("result[1] (lookup failure) = %+v, want Error set and Result nil", got[1])
+	}
+	if got[2].Error != "" || got[2].Result == nil {
+		t.Errorf("result[2] (success) = %+v, want Result set and Error empty", got[2])
+	}
+}
+
+func TestServeBulkMaxBatchSize(t *testing.T) {
+	db := &bulkStubDB{}
+	allSameIP := func(n int) []byte {
+		ips := make([]string, n)
+		for i := range ips {
+			ips[i] = "1.2.3.4"
+		}
+		body, _ := json.Marshal(ips)
+		return body
+	}
+
+	t.Run("malformed body is a 400", func(t *testing.T) {
+		hh := NewHTTPHandler(db)
+		req := httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader("not json"))
+		rec := httptest.NewRecorder()
+		hh.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("default limit allows exactly DefaultMaxBatchSize", func(t *testing.T) {
+		hh := NewHTTPHandler(db)
+		hh.DisableCache()
+		rec := postBulk(hh, allSameIP(DefaultMaxBatchSize))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("default limit rejects one over DefaultMaxBatchSize", func(t *testing.T) {
+		hh := NewHTTPHandler(db)
+		rec := postBulk(hh, allSameIP(DefaultMaxBatchSize+1))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("MaxBatchSize override raises the limit", func(t *testing.T) {
+		hh := NewHTTPHandler(db)
+		hh.DisableCache()
+		hh.MaxBatchSize = DefaultMaxBatchSize + 10
+		rec := postBulk(hh, allSameIP(DefaultMaxBatchSize+5))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("MaxBatchSize override still rejects its own over-limit batch", func(t *testing.T) {
+		hh := NewHTTPHandler(db)
+		hh.MaxBatchSize = 2
+		rec := postBulk(hh, allSameIP(3))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+// TestServeBulkPooledResultReuseUnderRace drives a large, all-distinct
+// batch through the worker pool so lookupResultPool's *LookupResult values
+// get reused across goroutines many times over; run with -race, this
+// catches any data race from a pooled value being read after Put (or
+// written by two workers at once) before it's safely copied out in
+// bulkLookupOne.
+func TestServeBulkPooledResultReuseUnderRace(t *testing.T) {
+	const n = 500
+	ips := make([]string, n)
+	for i := 0; i < n; i++ {
+		ips[i] = fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)
+	}
+	db := &bulkStubDB{}
+	hh := NewHTTPHandler(db)
+	hh.DisableCache()
+	hh.MaxBatchSize = n
+
+	body, _ := json.Marshal(ips)
+	rec := postBulk(hh, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	got := decodeBulkResponse(t, rec)
+	if len(got) != n {
+		t.Fatalf("got %d results, want %d", len(got), n)
+	}
+	for i, want := range ips {
+		if got[i].IP != want || got[i].Result == nil || got[i].Result.Country.ISOCode != want {
+			t.Fatalf("result[%d] = %+v, want IP and Country.ISOCode %q", i, got[i], want)
+		}
+	}
+}
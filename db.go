@@ -1,8 +1,12 @@
 package geominder
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/oschwald/maxminddb-golang"
 )
@@ -12,61 +16,212 @@ import (
 //
 // Additionally, this allows us to abstract and separate the DB lookup logic from
 // the HTTP handlers.
+//
+// The reader is held behind an atomic.Pointer so that Watch can hot-swap it
+// for a freshly-opened one without callers of Lookup/FastLookup needing to
+// synchronize; it's wrapped in a refReader so the swapped-out reader isn't
+// closed until every in-flight lookup against it has finished. See
+// watch.go.
 type LookupDB struct {
-	reader *maxminddb.Reader
+	reader atomic.Pointer[refReader]
+
+	// dbPath is the path NewLookupDB opened reader from, and the default
+	// path Watch re-stats and reopens, unless overridden by WatchPath.
+	dbPath    string
+	watchPath string
+
+	mu       sync.Mutex
+	onReload []func()
 }
 
 // LookupResult is a minimal set of location information that is queried for and
 // returned from our lookups.
+//
+// City, Subdivisions, Postal, Continent and RegisteredCountry are only present
+// in GeoIP2/GeoLite2 City-precision databases; they will simply be left at
+// their zero value when looking up a Country-precision database. Call
+// Localize (or let HTTPHandler do it for you) to resolve the Name fields from
+// the record's localized `names` maps.
 type LookupResult struct {
-	Country  country  `maxminddb:"country" json:"country"`
-	Location location `maxminddb:"location" json:"location"`
+	// XMLName names the root element when encoded as XML; see encode.go.
+	XMLName           xml.Name      `json:"-" xml:"result"`
+	Country           country       `maxminddb:"country" json:"country"`
+	RegisteredCountry country       `maxminddb:"registered_country" json:"registered_country,omitempty"`
+	Continent         continent     `maxminddb:"continent" json:"continent,omitempty"`
+	City              city          `maxminddb:"city" json:"city,omitempty"`
+	Subdivisions      []subdivision `maxminddb:"subdivisions" json:"subdivisions,omitempty"`
+	Postal            postal        `maxminddb:"postal" json:"postal,omitempty"`
+	Location          location      `maxminddb:"location" json:"location"`
+	// ASN is only populated by a MultiDB composed with an ASN-precision
+	// database; a plain LookupDB leaves it nil.
+	ASN *asn `json:"asn,omitempty"`
+}
+
+// Lookuper is satisfied by anything that can resolve an IP to a
+// LookupResult. LookupDB and MultiDB both implement it, so HTTPHandler can
+// be backed by either interchangeably.
+type Lookuper interface {
+	Lookup(ip net.IP) (*LookupResult, error)
 }
 
 // DEVS: For possible fields, see https://dev.maxmind.com/geoip/geoip2/web-services/
 // TODO: maybe make same as https://github.com/bluesmoon/node-geoip?
 
+// names is a locale-code -> localized name map, as returned by MaxMind for
+// the country, registered_country, continent, city and subdivision records.
+type names map[string]string
+
+// Localized returns the name for the first of prefs present in the map,
+// tried in order, falling back to "en", then to the empty string if none
+// of them are present either.
+func (n names) Localized(prefs ...string) string {
+	for _, lang := range prefs {
+		if v, ok := n[lang]; ok {
+			return v
+		}
+	}
+	if v, ok := n["en"]; ok {
+		return v
+	}
+	return ""
+}
+
 type country struct {
+	// Localized names for the country, keyed by locale code, e.g. "en", "de".
+	Names names `maxminddb:"names" json:"-" xml:"-"`
+	// Name is the localized name resolved by Localize(); empty until then.
+	Name string `json:"name,omitempty" xml:"name,omitempty"`
 	// A two-character ISO 3166-1 country code for the country associated with
 	// the IP address.
-	ISOCode string `maxminddb:"iso_code" json:"iso_code"`
+	ISOCode string `maxminddb:"iso_code" json:"iso_code" xml:"iso_code"`
+}
+
+// isZero reports whether c is an unpopulated country record, i.e. the
+// database had nothing for this subtree (or it was never decoded).
+func (c country) isZero() bool {
+	return c.Name == "" && c.ISOCode == "" && len(c.Names) == 0
+}
+
+type continent struct {
+	// Localized names for the continent, keyed by locale code.
+	Names names `maxminddb:"names" json:"-" xml:"-"`
+	// Name is the localized name resolved by Localize(); empty until then.
+	Name string `json:"name,omitempty" xml:"name,omitempty"`
+	// A two-character continent code, e.g. "NA", "EU".
+	Code string `maxminddb:"code" json:"code,omitempty" xml:"code,omitempty"`
+}
+
+// isZero reports whether c is an unpopulated continent record.
+func (c continent) isZero() bool {
+	return c.Name == "" && c.Code == "" && len(c.Names) == 0
+}
+
+type city struct {
+	// Localized names for the city, keyed by locale code.
+	Names names `maxminddb:"names" json:"-" xml:"-"`
+	// Name is the localized name resolved by Localize(); empty until then.
+	Name string `json:"name,omitempty" xml:"name,omitempty"`
+}
+
+// isZero reports whether c is an unpopulated city record.
+func (c city) isZero() bool {
+	return c.Name == "" && len(c.Names) == 0
+}
+
+type subdivision struct {
+	// Localized names for the subdivision, keyed by locale code.
+	Names names `maxminddb:"names" json:"-" xml:"-"`
+	// Name is the localized name resolved by Localize(); empty until then.
+	Name string `json:"name,omitempty" xml:"name,omitempty"`
+	// A subdivision code, e.g. "CA" for California.
+	ISOCode string `maxminddb:"iso_code" json:"iso_code,omitempty" xml:"iso_code,omitempty"`
+}
+
+type postal struct {
+	// The postal code associated with the IP address.
+	Code string `maxminddb:"code" json:"code,omitempty" xml:"code,omitempty"`
+}
+
+// isZero reports whether p is an unpopulated postal record.
+func (p postal) isZero() bool {
+	return p.Code == ""
 }
 
 type location struct {
 	// The approximate latitude of the postal code, city, subdivision or country
 	// associated with the IP address.
-	Latitude float64 `maxminddb:"latitude" json:"latitude"`
+	Latitude float64 `maxminddb:"latitude" json:"latitude" xml:"latitude"`
 	// The approximate longitude of the postal code, city, subdivision or
 	// country associated with the IP address.
-	Longitude float64 `maxminddb:"longitude" json:"longitude"`
+	Longitude float64 `maxminddb:"longitude" json:"longitude" xml:"longitude"`
 	// The approximate accuracy radius, in kilometers, around the
 	// latitude and longitude for the geographical entity (country,
 	// subdivision, city or postal code) associated with the IP address.
 	// We have a 67% confidence that the location of the end-user falls
 	// within the area defined by the accuracy radius and the latitude
 	// and longitude coordinates.
-	Accuracy int `maxminddb:"accuracy_radius" json:"accuracy_radius"`
+	Accuracy int `maxminddb:"accuracy_radius" json:"accuracy_radius" xml:"accuracy_radius"`
 	// The time zone associated with location, as specified by the IANA
 	// Time Zone Database, e.g., “America/New_York”.
-	// Timezone string `maxminddb:"time_zone"`
+	Timezone string `maxminddb:"time_zone" json:"time_zone,omitempty" xml:"time_zone,omitempty"`
+}
+
+// Field identifies a decodable subtree of a GeoIP2 City record. Pass one or
+// more to LookupDB.LookupFields to restrict which subtrees end up populated
+// in the result.
+type Field int
+
+// Supported Field values for LookupFields.
+const (
+	FieldCountry Field = iota
+	FieldRegisteredCountry
+	FieldContinent
+	FieldCity
+	FieldSubdivisions
+	FieldPostal
+	FieldLocation
+)
+
+// Localize resolves the localized Name field on Country, RegisteredCountry,
+// Continent, City and each Subdivision, trying langs in order and falling
+// back to "en" if none of them are present in a given subtree's names map.
+//
+// It is a no-op for any subtree that was not decoded (e.g. because a
+// Country-precision database was loaded, or LookupFields excluded it).
+func (r *LookupResult) Localize(langs ...string) {
+	r.Country.Name = r.Country.Names.Localized(langs...)
+	r.RegisteredCountry.Name = r.RegisteredCountry.Names.Localized(langs...)
+	r.Continent.Name = r.Continent.Names.Localized(langs...)
+	r.City.Name = r.City.Names.Localized(langs...)
+	for i := range r.Subdivisions {
+		r.Subdivisions[i].Name = r.Subdivisions[i].Names.Localized(langs...)
+	}
 }
 
 // NewLookupDB open a new DB reader.
 //
 // dbPath must be the path to a valid maxmindDB file containing city precision.
-func NewLookupDB(dbPath string) (*LookupDB, error) {
+func NewLookupDB(dbPath string, opts ...LookupDBOption) (*LookupDB, error) {
 	db, err := maxminddb.Open(dbPath)
 	if err != nil {
 		return nil, err
 	}
-	return &LookupDB{reader: db}, nil
+	l := &LookupDB{dbPath: dbPath}
+	l.reader.Store(newRefReader(db))
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
 }
 
 // Close closes the underlying database and returns resources to the system.
 //
-// For current implemetnation, see maxminddb.Reader.Close()
+// If Lookup/FastLookup calls are still in flight against the current
+// reader, the underlying maxminddb.Reader isn't actually closed until they
+// finish; Close itself always returns immediately, returning nil in that
+// case since there's no close error to report yet.
 func (l *LookupDB) Close() error {
-	return l.reader.Close()
+	return l.reader.Load().release()
 }
 
 // Lookup returns the results for a given IP address, or an error if results can
@@ -89,19 +244,102 @@ func (l *LookupDB) FastLookup(ip net.IP, r *LookupResult) error {
 	return l.lookup(ip, r)
 }
 
+// fieldSpec describes one LookupResult subtree that LookupFields can
+// selectively decode: the field's name and type in LookupResult (so we can
+// build a matching subset struct via reflection) and the maxminddb tag that
+// ties it to the on-disk record.
+type fieldSpec struct {
+	field Field
+	name  string
+	typ   reflect.Type
+	tag   reflect.StructTag
+}
+
+var fieldSpecs = []fieldSpec{
+	{FieldCountry, "Country", reflect.TypeOf(country{}), `maxminddb:"country"`},
+	{FieldRegisteredCountry, "RegisteredCountry", reflect.TypeOf(country{}), `maxminddb:"registered_country"`},
+	{FieldContinent, "Continent", reflect.TypeOf(continent{}), `maxminddb:"continent"`},
+	{FieldCity, "City", reflect.TypeOf(city{}), `maxminddb:"city"`},
+	{FieldSubdivisions, "Subdivisions", reflect.TypeOf([]subdivision{}), `maxminddb:"subdivisions"`},
+	{FieldPostal, "Postal", reflect.TypeOf(postal{}), `maxminddb:"postal"`},
+	{FieldLocation, "Location", reflect.TypeOf(location{}), `maxminddb:"location"`},
+}
+
+// LookupFields behaves like Lookup, but only decodes the subtrees named in
+// fields: maxminddb-golang skips any record key it finds no matching struct
+// field for, so a caller that only wants e.g. FieldCountry never pays to
+// walk City, Subdivisions or the rest. Passing no fields behaves like
+// Lookup.
+func (l *LookupDB) LookupFields(ip net.IP, fields ...Field) (*LookupResult, error) {
+	if len(fields) == 0 {
+		return l.Lookup(ip)
+	}
+
+	want := make(map[Field]bool, len(fields))
+	for _, f := range fields {
+		want[f] = true
+	}
+
+	var structFields []reflect.StructField
+	for _, spec := range fieldSpecs {
+		if want[spec.field] {
+			structFields = append(structFields, reflect.StructField{
+				Name: spec.name,
+				Type: spec.typ,
+				Tag:  spec.tag,
+			})
+		}
+	}
+	subset := reflect.New(reflect.StructOf(structFields))
+	if err := l.decodeInto(ip, subset.Interface()); err != nil {
+		return nil, err
+	}
+
+	var r LookupResult
+	rVal := reflect.ValueOf(&r).Elem()
+	subsetVal := subset.Elem()
+	for _, spec := range fieldSpecs {
+		if want[spec.field] {
+			rVal.FieldByName(spec.name).Set(subsetVal.FieldByName(spec.name))
+		}
+	}
+	return &r, nil
+}
+
+func (l *LookupDB) lookup(ip net.IP, r *LookupResult) error {
+	return l.decodeInto(ip, r)
+}
+
+// databaseType reports the MaxMind DatabaseType metadata string for the
+// underlying reader, e.g. "GeoLite2-City" or "GeoLite2-ASN". MultiDB uses it
+// to tell an ASN database apart from a Country/City one.
+func (l *LookupDB) databaseType() string {
+	return l.reader.Load().reader.Metadata.DatabaseType
+}
+
 // oschwald/maxminddb-golang does not generate an error on a failed lookup,
 // see: https://github.com/oschwald/maxminddb-golang/issues/41
 //
 // to work around this, we don't use their Lookup(), but rather check
 // LookupOffset() first, and throw our own error if nothing was found, before
 // using the offset for a manual Decode().
-func (l *LookupDB) lookup(ip net.IP, r *LookupResult) error {
-	offset, err := l.reader.LookupOffset(ip)
+func (l *LookupDB) decodeInto(ip net.IP, v interface{}) error {
+	var rr *refReader
+	for {
+		rr = l.reader.Load()
+		if rr.acquire() {
+			break
+		}
+	}
+	defer rr.release()
+
+	reader := rr.reader
+	offset, err := reader.LookupOffset(ip)
 	if err != nil {
 		return err
 	}
 	if offset == maxminddb.NotFound {
 		return fmt.Errorf("no match for %v found in database", ip)
 	}
-	return l.reader.Decode(offset, r)
-}
\ No newline at end of file
+	return reader.Decode(offset, v)
+}
@@ -0,0 +1,74 @@
+package geominder
+
+import (
+	"net"
+	"testing"
+)
+
+func openTestCityDB(t *testing.T) *LookupDB {
+	t.Helper()
+	db, err := NewLookupDB("testdata/city-v1.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLookupFieldsRestrictsDecodedSubtrees(t *testing.T) {
+	db := openTestCityDB(t)
+	ip := net.ParseIP("1.2.3.4")
+
+	r, err := db.LookupFields(ip, FieldCountry)
+	if err != nil {
+		t.Fatalf("LookupFields: %v", err)
+	}
+	if r.Country.ISOCode != "US" {
+		t.Fatalf("Country.ISOCode = %q, want US", r.Country.ISOCode)
+	}
+	if r.City.Name != "" || len(r.City.Names) != 0 {
+		t.Fatalf("City = %+v, want zero value (not requested)", r.City)
+	}
+	if r.Subdivisions != nil {
+		t.Fatalf("Subdivisions = %+v, want nil (not requested)", r.Subdivisions)
+	}
+	if r.Postal.Code != "" {
+		t.Fatalf("Postal = %+v, want zero value (not requested)", r.Postal)
+	}
+	if r.Location.Timezone != "" {
+		t.Fatalf("Location = %+v, want zero value (not requested)", r.Location)
+	}
+}
+
+func TestLocalizePrefersHighestPriorityPresentLang(t *testing.T) {
+	r := &LookupResult{
+		Country: country{Names: names{"en": "France", "fr": "France", "de": "Frankreich"}},
+	}
+
+	r.Localize("zh", "de", "en")
+	if r.Country.Name != "Frankreich" {
+		t.Fatalf(`Localize("zh", "de", "en") = %q, want "Frankreich" (first candidate present in Names)`, r.Country.Name)
+	}
+
+	r.Localize("zh", "ja")
+	if r.Country.Name != "France" {
+		t.Fatalf(`Localize("zh", "ja") = %q, want "France" (falls back to "en" when no candidate matches)`, r.Country.Name)
+	}
+}
+
+func TestLookupFieldsNoneBehavesLikeLookup(t *testing.T) {
+	db := openTestCityDB(t)
+	ip := net.ParseIP("1.2.3.4")
+
+	want, err := db.Lookup(ip)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	got, err := db.LookupFields(ip)
+	if err != nil {
+		t.Fatalf("LookupFields: %v", err)
+	}
+	if got.Country.ISOCode != want.Country.ISOCode || got.City.Names["en"] != want.City.Names["en"] {
+		t.Fatalf("LookupFields() = %+v, want %+v", got, want)
+	}
+}
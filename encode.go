@@ -0,0 +1,203 @@
+package geominder
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoder renders a LookupResult in a particular wire format. Register one
+// in encoders, keyed by the short format name used by the "?format="
+// parameter and the "/<ip>.<format>" URL suffix.
+type Encoder interface {
+	// ContentType is written as the response's Content-Type header.
+	ContentType() string
+	// Encode renders result onto buf.
+	Encode(buf *bytes.Buffer, result *LookupResult) error
+}
+
+// encoders holds every format HTTPHandler can render a LookupResult as,
+// keyed by the short format name.
+var encoders = map[string]Encoder{
+	"json":    jsonEncoder{},
+	"xml":     xmlEncoder{},
+	"csv":     csvEncoder{},
+	"msgpack": msgpackEncoder{},
+}
+
+// mimeFormats maps an Accept header MIME type to the format name it
+// negotiates to in encoders.
+var mimeFormats = map[string]string{
+	"application/json":      "json",
+	"application/xml":       "xml",
+	"text/xml":              "xml",
+	"text/csv":              "csv",
+	"application/x-msgpack": "msgpack",
+}
+
+// DefaultFormat is used when a request names no format HTTPHandler
+// recognizes, whether via URL suffix, "?format=" or Accept header.
+const DefaultFormat = "json"
+
+// negotiateFormat picks the response format for a request and returns it
+// alongside ipText with any recognized "." suffix stripped.
+//
+// Precedence: a "/<ip>.<format>" URL suffix, then "?format=", then Accept
+// header negotiation, then DefaultFormat.
+func negotiateFormat(r *http.Request, ipText string) (format, trimmedIPText string) {
+	if i := strings.LastIndexByte(ipText, '.'); i >= 0 {
+		if ext := ipText[i+1:]; encoders[ext] != nil {
+			return ext, ipText[:i]
+		}
+	}
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := encoders[f]; ok {
+			return f, ipText
+		}
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if f, ok := mimeFormats[mime]; ok {
+			return f, ipText
+		}
+	}
+	return DefaultFormat, ipText
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Encode(buf *bytes.Buffer, r *LookupResult) error {
+	return json.NewEncoder(buf).Encode(r)
+}
+
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) Encode(buf *bytes.Buffer, r *LookupResult) error {
+	return xml.NewEncoder(buf).Encode(r)
+}
+
+// xmlResult mirrors LookupResult's schema for XML output, but replaces the
+// "only present on City-precision databases" sub-records with pointers so
+// MarshalXML can actually omit them when unpopulated -- encoding/xml has no
+// omitempty support for struct-valued fields, only for pointers and slices.
+type xmlResult struct {
+	XMLName           xml.Name      `xml:"result"`
+	Country           country       `xml:"country"`
+	RegisteredCountry *country      `xml:"registered_country,omitempty"`
+	Continent         *continent    `xml:"continent,omitempty"`
+	City              *city         `xml:"city,omitempty"`
+	Subdivisions      []subdivision `xml:"subdivisions>subdivision,omitempty"`
+	Postal            *postal       `xml:"postal,omitempty"`
+	Location          location      `xml:"location"`
+	ASN               *asn          `xml:"asn,omitempty"`
+}
+
+// MarshalXML renders r following the same field names and "omit when
+// unpopulated" schema as the other encoders (json, msgpack), rather than
+// encoding/xml's default of always emitting every City-only sub-record.
+func (r *LookupResult) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	out := xmlResult{
+		XMLName:      r.XMLName,
+		Country:      r.Country,
+		Subdivisions: r.Subdivisions,
+		Location:     r.Location,
+		ASN:          r.ASN,
+	}
+	if !r.RegisteredCountry.isZero() {
+		out.RegisteredCountry = &r.RegisteredCountry
+	}
+	if !r.Continent.isZero() {
+		out.Continent = &r.Continent
+	}
+	if !r.City.isZero() {
+		out.City = &r.City
+	}
+	if !r.Postal.isZero() {
+		out.Postal = &r.Postal
+	}
+	start.Name.Local = "result"
+	return e.EncodeElement(out, start)
+}
+
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string { return "application/x-msgpack" }
+
+func (msgpackEncoder) Encode(buf *bytes.Buffer, r *LookupResult) error {
+	enc := msgpack.NewEncoder(buf)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(r)
+}
+
+// csvEncoder renders the subset of LookupResult that fits naturally into a
+// single flat row; callers that need the full record should use json/xml.
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv" }
+
+var csvHeader = []string{
+	"country_iso_code", "country_name",
+	"city_name",
+	"postal_code",
+	"latitude", "longitude", "accuracy_radius", "time_zone",
+}
+
+func (csvEncoder) Encode(buf *bytes.Buffer, r *LookupResult) error {
+	w := csv.NewWriter(buf)
+	if err := w.Write(csvHeader); err != nil {
+		return err
+	}
+	row := []string{
+		r.Country.ISOCode, r.Country.Name,
+		r.City.Name,
+		r.Postal.Code,
+		strconv.FormatFloat(r.Location.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(r.Location.Longitude, 'f', -1, 64),
+		strconv.Itoa(r.Location.Accuracy),
+		r.Location.Timezone,
+	}
+	if err := w.Write(row); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// wrapJSONP wraps JSON-encoded b as a JavaScript callback invocation, per
+// the "?callback=" JSONP convention. callback must already have passed
+// sanitizeCallback; it's reflected into the response body verbatim.
+func wrapJSONP(callback string, b []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(callback)
+	buf.WriteByte('(')
+	buf.Write(bytes.TrimRight(b, "\n"))
+	buf.WriteString(");")
+	return buf.Bytes()
+}
+
+// jsonpCallbackPattern matches safe JSONP callback identifiers: a dotted,
+// optionally bracket-indexed JS property path like "foo", "angular.cb._0"
+// or "foo[0]". Nothing else is accepted.
+var jsonpCallbackPattern = regexp.MustCompile(`^[\w$]+(?:(?:\.[\w$]+)|(?:\[\d+\]))*$`)
+
+// sanitizeCallback returns callback unchanged if it's a safe JSONP
+// identifier per jsonpCallbackPattern, or "" otherwise, meaning "don't do
+// JSONP for this request" rather than reflecting arbitrary script into the
+// response body.
+func sanitizeCallback(callback string) string {
+	if !jsonpCallbackPattern.MatchString(callback) {
+		return ""
+	}
+	return callback
+}
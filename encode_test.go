@@ -0,0 +1,148 @@
+package geominder
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func sampleResult() *LookupResult {
+	r := &LookupResult{
+		Country: country{ISOCode: "US", Names: names{"en": "United States"}},
+		Location: location{
+			Latitude: 37.77, Longitude: -122.41, Accuracy: 10, Timezone: "America/Los_Angeles",
+		},
+	}
+	r.Localize("en")
+	return r
+}
+
+// TestMsgpackEncoderMatchesJSONSchema guards against the msgpack encoder
+// drifting onto vmihailenco/msgpack's default field-name/tag behavior
+// instead of the same `json` tags (and the `json:"-"` fields they hide)
+// every other encoder in this file follows.
+//
+// Note: vmihailenco/msgpack's omitempty treats a zero-value struct field
+// (e.g. Continent, City, Postal) as empty, unlike encoding/json, which
+// never omits struct-typed fields regardless of "omitempty" -- so those
+// always-present-in-JSON keys are legitimately absent here too.
+func TestMsgpackEncoderMatchesJSONSchema(t *testing.T) {
+	r := sampleResult()
+
+	var msgpackBuf bytes.Buffer
+	if err := (msgpackEncoder{}).Encode(&msgpackBuf, r); err != nil {
+		t.Fatalf("msgpackEncoder.Encode: %v", err)
+	}
+	var got map[string]interface{}
+	dec := msgpack.NewDecoder(&msgpackBuf)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("decoding msgpack output: %v", err)
+	}
+
+	countryField, _ := got["country"].(map[string]interface{})
+	if countryField["iso_code"] != "US" || countryField["name"] != "United States" {
+		t.Errorf(`country = %+v, want iso_code "US" and name "United States"`, countryField)
+	}
+	if _, ok := got["Names"]; ok {
+		t.Error(`msgpack output leaks "Names", which every json:"-" field should hide`)
+	}
+	if _, ok := countryField["Names"]; ok {
+		t.Error(`msgpack output leaks country.Names, which json:"-" should hide`)
+	}
+	if _, ok := got["asn"]; ok {
+		t.Error(`msgpack output has "asn" key despite a nil ASN and json:"asn,omitempty"`)
+	}
+}
+
+// TestSanitizeCallbackRejectsUnsafeIdentifiers guards wrapJSONP against
+// reflecting arbitrary script into the response body via "?callback=";
+// only safe, dotted/bracket-indexed JS identifiers should pass through.
+func TestSanitizeCallbackRejectsUnsafeIdentifiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		callback string
+		want     string
+	}{
+		{"bare identifier", "foo", "foo"},
+		{"dotted path", "angular.callbacks._0", "angular.callbacks._0"},
+		{"bracket index", "foo[0]", "foo[0]"},
+		{"script tag", "<script>alert(1)</script>", ""},
+		{"call expression", "foo(bar)", ""},
+		{"statement injection", "foo;alert(1)", ""},
+		{"whitespace", "foo bar", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeCallback(tt.callback); got != tt.want {
+				t.Errorf("sanitizeCallback(%q) = %q, want %q", tt.callback, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestXMLEncoderMatchesJSONSchema guards against the XML encoder reverting
+// to encoding/xml's default behavior of always emitting every City-only
+// sub-record (RegisteredCountry, Continent, City, Postal) even when the
+// underlying database had nothing for that subtree.
+func TestXMLEncoderMatchesJSONSchema(t *testing.T) {
+	r := sampleResult()
+
+	var buf bytes.Buffer
+	if err := (xmlEncoder{}).Encode(&buf, r); err != nil {
+		t.Fatalf("xmlEncoder.Encode: %v", err)
+	}
+
+	var got struct {
+		XMLName xml.Name `xml:"result"`
+		Country struct {
+			ISOCode string `xml:"iso_code"`
+			Name    string `xml:"name"`
+		} `xml:"country"`
+		RegisteredCountry *struct{} `xml:"registered_country"`
+		Continent         *struct{} `xml:"continent"`
+		City              *struct{} `xml:"city"`
+		Postal            *struct{} `xml:"postal"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding xml output: %v", err)
+	}
+
+	if got.Country.ISOCode != "US" || got.Country.Name != "United States" {
+		t.Errorf(`country = %+v, want iso_code "US" and name "United States"`, got.Country)
+	}
+	if got.RegisteredCountry != nil {
+		t.Error("xml output has a <registered_country> element despite an unpopulated RegisteredCountry")
+	}
+	if got.Continent != nil {
+		t.Error("xml output has a <continent> element despite an unpopulated Continent")
+	}
+	if got.City != nil {
+		t.Error("xml output has a <city> element despite an unpopulated City")
+	}
+	if got.Postal != nil {
+		t.Error("xml output has a <postal> element despite an unpopulated Postal")
+	}
+	if bytes.Contains(buf.Bytes(), []byte("Names")) {
+		t.Error(`xml output leaks "Names", which xml:"-" should hide`)
+	}
+}
+
+func TestCSVEncoderHeaderAndRow(t *testing.T) {
+	r := sampleResult()
+
+	var buf bytes.Buffer
+	if err := (csvEncoder{}).Encode(&buf, r); err != nil {
+		t.Fatalf("csvEncoder.Encode: %v", err)
+	}
+
+	want := "country_iso_code,country_name,city_name,postal_code,latitude,longitude,accuracy_radius,time_zone\n" +
+		"US,United States,,,37.77,-122.41,10,America/Los_Angeles\n"
+	if buf.String() != want {
+		t.Fatalf("csv output = %q, want %q", buf.String(), want)
+	}
+}
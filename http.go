@@ -1,10 +1,12 @@
 package geominder
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,8 +25,8 @@ const DefaultOriginPolicy = "*"
 // HTTPHandler implements a standard http.Handler interface for accessing
 // a LookupDB, and provides in-memory caching for results.
 type HTTPHandler struct {
-	// Handle to the LookupDB used for queries.
-	DB *LookupDB
+	// Handle to the LookupDB (or MultiDB) used for queries.
+	DB Lookuper
 	// Value for `Access-Control-Allow-Origin` header.
 	//
 	// Header will be omitted if set to zero value.
@@ -34,20 +36,54 @@ type HTTPHandler struct {
 	// TODO: before v1.0, the memcache should potentially be privatized so that
 	// API stability can be more easily preserved if it is switched out.
 	MemCache *bigcache.BigCache
+	// TrustedHeaders lists, in priority order, the proxy headers that may
+	// carry the caller's real IP (e.g. "X-Forwarded-For", "X-Real-IP",
+	// "CF-Connecting-IP"). Only consulted for "/" and "/self" requests, and
+	// only once RemoteAddr itself is found in TrustedProxies.
+	TrustedHeaders []string
+	// TrustedProxies allow-lists the proxies permitted to set TrustedHeaders.
+	// An address is only taken from a header if everything between the
+	// socket peer and it is inside one of these CIDRs.
+	TrustedProxies []*net.IPNet
+	// Metrics, if set, receives lookup/cache counters and histograms for
+	// every request. See MetricsRecorder.
+	Metrics MetricsRecorder
+	// Logger, if set, receives one structured RequestLogEntry per request.
+	Logger Logger
+	// MaxBatchSize bounds how many IPs a single POST /bulk request may
+	// contain. Zero means DefaultMaxBatchSize.
+	MaxBatchSize int
 }
 
-// NewHTTPHandler creates a HTTPHandler for requests againt the given LookupDB
+// NewHTTPHandler creates a HTTPHandler for requests against the given
+// Lookuper (typically a *LookupDB, or a *MultiDB -- see NewHTTPHandlerMulti).
 //
 // By default caching is enabled, and DefaultOriginPolicy is applied.
-func NewHTTPHandler(db *LookupDB) *HTTPHandler {
+func NewHTTPHandler(db Lookuper) *HTTPHandler {
 	hh := HTTPHandler{
 		DB:           db,
 		OriginPolicy: DefaultOriginPolicy,
 	}
 	hh.EnableCache()
+	if r, ok := db.(Reloadable); ok {
+		r.OnReload(hh.flushCache)
+	}
 	return &hh
 }
 
+// NewHTTPHandlerMulti creates a HTTPHandler backed by a MultiDB composed from
+// dbs, so e.g. a GeoLite2-City database and a GeoLite2-ASN database can be
+// served together from one endpoint.
+//
+// By default caching is enabled, and DefaultOriginPolicy is applied.
+func NewHTTPHandlerMulti(dbs ...*LookupDB) (*HTTPHandler, error) {
+	m, err := NewMultiDB(dbs...)
+	if err != nil {
+		return nil, err
+	}
+	return NewHTTPHandler(m), nil
+}
+
 // EnableCache activates the memory cache for a HTTPHandler with default values.
 //
 // Returns pointer to the HTTPHandler to enable chaining in builder pattern.
@@ -84,6 +120,15 @@ func (hh *HTTPHandler) DisableCache() *HTTPHandler {
 	return hh
 }
 
+// flushCache empties the memory cache, discarding every entry without
+// closing it. Registered as a Reloadable hook so answers cached from before
+// a LookupDB.Watch hot-reload are never served stale.
+func (hh *HTTPHandler) flushCache() {
+	if hh.MemCache != nil {
+		hh.MemCache.Reset()
+	}
+}
+
 // SetOriginPolicy sets value for `Access-Control-Allow-Origin` header
 //
 // Returns pointer to the HTTPHandler to enable chaining in builder pattern.
@@ -92,59 +137,268 @@ func (hh *HTTPHandler) SetOriginPolicy(origins string) *HTTPHandler {
 	return hh
 }
 
+// clientIP resolves the caller's address for a "/" or "/self" request.
+//
+// It starts from r.RemoteAddr; if that's not in TrustedProxies, it's
+// returned as-is. Otherwise it walks TrustedHeaders in order, and within
+// each header's (possibly comma-separated) value from right to left,
+// skipping addresses that are themselves trusted proxies, returning the
+// first one that isn't.
+func (hh *HTTPHandler) clientIP(r *http.Request) string {
+	candidate, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		candidate = r.RemoteAddr
+	}
+	if !hh.isTrustedProxy(candidate) {
+		return candidate
+	}
+
+	for _, header := range hh.TrustedHeaders {
+		values := r.Header.Get(header)
+		if values == "" {
+			continue
+		}
+		parts := strings.Split(values, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(parts[i])
+			if addr == "" {
+				continue
+			}
+			if !hh.isTrustedProxy(addr) {
+				return addr
+			}
+			candidate = addr
+		}
+	}
+	return candidate
+}
+
+// isTrustedProxy reports whether addr parses as an IP inside one of
+// TrustedProxies.
+func (hh *HTTPHandler) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range hh.TrustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // ServeHTTP implements the http.Handler interface
 func (hh *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && (r.URL.Path == "/bulk" || r.URL.Path == "/lookup") {
+		hh.serveBulk(w, r)
+		return
+	}
+
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	var cacheHit bool
+	defer func() {
+		hh.logRequest(r, sw.status(), time.Since(start), cacheHit)
+	}()
+
 	// Set headers
 	if hh.OriginPolicy != "" {
-		w.Header().Set("Access-Control-Allow-Origin", hh.OriginPolicy)
+		sw.Header().Set("Access-Control-Allow-Origin", hh.OriginPolicy)
 	}
-	w.Header().Set("Content-Type", "application/json")
 	// w.Header().Set("Last-Modified", serverStart)
 
-	// attempt to parse IP from query
+	// attempt to parse IP from query, stripping any "/<ip>.<format>" suffix
+	// first so "/self.json" resolves the same way "/self" does.
 	ipText := strings.TrimPrefix(r.URL.Path, "/")
+	format, ipText := negotiateFormat(r, ipText)
+	encoder := encoders[format]
+	callback := sanitizeCallback(r.URL.Query().Get("callback"))
+
+	// "/" and "/self" both mean "look up the caller", auto-detected from
+	// RemoteAddr and TrustedHeaders.
+	if ipText == "" || ipText == "self" {
+		ipText = hh.clientIP(r)
+	}
 
 	// nice error message when missing data
 	if ipText == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		sw.Header().Set("Content-Type", "application/json")
+		sw.WriteHeader(http.StatusBadRequest)
 		const parseIPError = `{"error": "missing IP query parameter, try ?ip=foo"}`
-		w.Write([]byte(parseIPError))
+		sw.Write([]byte(parseIPError))
 		return
 	}
 
+	langs := negotiateLangs(r)
+	cacheKey := ipText + "|" + strings.Join(langs, ",") + "|" + format
+	if callback != "" {
+		cacheKey += "|" + callback
+	}
+
 	// check for cached result
 	if hh.MemCache != nil {
-		cached, err := hh.MemCache.Get(ipText) // EntryNotFoundError on cache miss
+		cached, err := hh.MemCache.Get(cacheKey) // EntryNotFoundError on cache miss
 		if err == nil {
-			w.Write(cached)
+			cacheHit = true
+			hh.recordCache(true)
+			sw.Header().Set("Content-Type", jsonpOr(callback, encoder.ContentType()))
+			sw.Write(cached)
 			return
 		}
 	}
+	hh.recordCache(false)
 
 	// attempt to parse the provided IP address
 	ip := net.ParseIP(ipText)
 	if ip == nil {
-		w.WriteHeader(http.StatusBadRequest)
+		sw.Header().Set("Content-Type", "application/json")
+		sw.WriteHeader(http.StatusBadRequest)
 		const parseIPError = `{"error": "could not parse invalid IP address"}`
-		w.Write([]byte(parseIPError))
+		sw.Write([]byte(parseIPError))
 		return
 	}
 
 	// do a DB lookup on the IP address
+	lookupStart := time.Now()
 	loc, err := hh.DB.Lookup(ip)
+	hh.recordLookup(time.Since(lookupStart), err)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err.Error())))
+		sw.Header().Set("Content-Type", "application/json")
+		sw.WriteHeader(http.StatusInternalServerError)
+		sw.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err.Error())))
 		return
 	}
+	loc.Localize(langs...)
 
-	// rerturn results as JSON + update in cache if cache enabled
+	// render in the negotiated format + update in cache if cache enabled
 	//
-	// (yes, we're swallowing a potential marshall error here, but we already
+	// (yes, we're swallowing a potential encoding error here, but we already
 	// know loc should not be nil since we checked for err on the previous case)
-	b, _ := json.Marshal(loc)
-	w.Write(b)
+	var buf bytes.Buffer
+	_ = encoder.Encode(&buf, loc)
+	b := buf.Bytes()
+	if callback != "" {
+		b = wrapJSONP(callback, b)
+	}
+	sw.Header().Set("Content-Type", jsonpOr(callback, encoder.ContentType()))
+	sw.Write(b)
 	if hh.MemCache != nil {
-		hh.MemCache.Set(ipText, b)
+		hh.MemCache.Set(cacheKey, b)
+	}
+}
+
+// jsonpOr returns "application/javascript" when callback is set (JSONP
+// wraps the body in a function call, so it isn't valid JSON anymore),
+// otherwise it returns contentType unchanged.
+func jsonpOr(callback, contentType string) string {
+	if callback != "" {
+		return "application/javascript"
+	}
+	return contentType
+}
+
+// recordCache reports a single cache hit/miss to Metrics, if set, along
+// with MemCache's current size.
+func (hh *HTTPHandler) recordCache(hit bool) {
+	if hh.Metrics == nil {
+		return
+	}
+	hh.Metrics.CacheResult(hit)
+	if hh.MemCache != nil {
+		hh.Metrics.CacheSize(hh.MemCache.Len(), hh.MemCache.Capacity())
+	}
+}
+
+// recordLookup reports a single LookupDB.Lookup call's duration and outcome
+// to Metrics, if set.
+func (hh *HTTPHandler) recordLookup(d time.Duration, err error) {
+	if hh.Metrics == nil {
+		return
+	}
+	hh.Metrics.LookupDuration(d)
+	switch {
+	case err == nil:
+		hh.Metrics.LookupResult("ok")
+	case strings.Contains(err.Error(), "no match"):
+		hh.Metrics.LookupResult("not_found")
+	default:
+		hh.Metrics.LookupResult("decode_error")
+	}
+}
+
+// logRequest emits a RequestLogEntry to Logger, if set.
+func (hh *HTTPHandler) logRequest(r *http.Request, status int, latency time.Duration, cacheHit bool) {
+	if hh.Logger == nil {
+		return
 	}
+	hh.Logger.LogRequest(RequestLogEntry{
+		IP:       r.RemoteAddr,
+		Status:   status,
+		Latency:  latency,
+		CacheHit: cacheHit,
+	})
+}
+
+// negotiateLangs returns the caller's localization preferences as an
+// ordered list of base language tags, highest priority first: an explicit
+// "?lang=" always comes first, followed by Accept-Language's tags ordered
+// by descending q-value (RFC 7231 content negotiation).
+//
+// Localize tries each of these against the actual decoded record, so a
+// lower-priority tag that IS present in its `names` map wins over a
+// higher-priority tag that isn't, before finally falling back to "en".
+//
+// Only the base language subtag is used (e.g. "pt" from "pt-BR"), since
+// that's the granularity MaxMind's `names` maps are keyed by.
+func negotiateLangs(r *http.Request) []string {
+	var langs []string
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		langs = append(langs, baseLangTag(lang))
+	}
+	return append(langs, acceptLanguageTags(r.Header.Get("Accept-Language"))...)
+}
+
+// acceptLanguageTags parses an Accept-Language header value into base
+// language tags, ordered by descending q-value (default q=1.0 when
+// unspecified). Tags with a malformed q-value fall back to 1.0 rather than
+// being dropped; the wildcard "*" is ignored since it names no actual
+// locale to try against a names map.
+func acceptLanguageTags(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var weightedTags []weighted
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "*" {
+			continue
+		}
+		tag, q := part, 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			tag = strings.TrimSpace(part[:i])
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(part[i+1:]), "q="); ok {
+				if parsed, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if tag == "" || tag == "*" {
+			continue
+		}
+		weightedTags = append(weightedTags, weighted{baseLangTag(tag), q})
+	}
+	sort.SliceStable(weightedTags, func(i, j int) bool { return weightedTags[i].q > weightedTags[j].q })
+	tags := make([]string, len(weightedTags))
+	for i, wt := range weightedTags {
+		tags[i] = wt.tag
+	}
+	return tags
+}
+
+// baseLangTag strips any region/script subtag, e.g. "pt-BR" -> "pt", and
+// lowercases the result to match the casing used by MaxMind's `names` maps.
+func baseLangTag(tag string) string {
+	return strings.ToLower(strings.SplitN(tag, "-", 2)[0])
 }
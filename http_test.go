@@ -0,0 +1,262 @@
+package geominder
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubLookuper struct {
+	result *LookupResult
+	err    error
+}
+
+func (s stubLookuper) Lookup(ip net.IP) (*LookupResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	r := *s.result
+	return &r, nil
+}
+
+func TestServeHTTPSelfFormatSuffix(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		wantStatus      int
+		wantContentType string
+	}{
+		{"bare self", "/self", http.StatusOK, "application/json"},
+		{"self with json suffix", "/self.json", http.StatusOK, "application/json"},
+		{"self with csv suffix", "/self.csv", http.StatusOK, "text/csv"},
+		{"root path", "/", http.StatusOK, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hh := NewHTTPHandler(stubLookuper{result: &LookupResult{Country: country{ISOCode: "US"}}})
+			hh.DisableCache()
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "203.0.113.5:1234"
+			rec := httptest.NewRecorder()
+
+			hh.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", ct, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestAcceptLanguageTagsOrdersByQValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{"no q-values keeps header order", "fr, en", []string{"fr", "en"}},
+		{"explicit q-values reorder", "en;q=0.1, fr;q=0.9, de;q=0.5", []string{"fr", "de", "en"}},
+		{"region subtags reduced to base", "pt-BR;q=0.8, en-US;q=0.9", []string{"en", "pt"}},
+		{"wildcard is ignored", "*, en;q=0.5", []string{"en"}},
+		{"malformed q-value defaults to 1.0", "fr;q=bogus, en;q=0.2", []string{"fr", "en"}},
+		{"empty header", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := acceptLanguageTags(tt.header)
+			if len(got) != len(tt.want) {
+				t.Fatalf("acceptLanguageTags(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("acceptLanguageTags(%q) = %v, want %v", tt.header, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiateLangsPrefersQueryParamOverHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/self?lang=es-MX", nil)
+	req.Header.Set("Accept-Language", "fr;q=0.9, de;q=0.1")
+
+	got := negotiateLangs(req)
+	want := []string{"es", "fr", "de"}
+	if len(got) != len(want) {
+		t.Fatalf("negotiateLangs() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("negotiateLangs() = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestServeHTTPLocalizesWithBestAvailableLang exercises negotiateLangs and
+// Localize together: the client's highest-priority language ("zh") isn't in
+// the record's names map, so the next-best candidate actually present
+// ("de") should win over both "zh" and the "en" fallback.
+func TestServeHTTPLocalizesWithBestAvailableLang(t *testing.T) {
+	hh := NewHTTPHandler(stubLookuper{result: &LookupResult{
+		Country: country{
+			ISOCode: "FR",
+			Names:   names{"en": "France", "de": "Frankreich"},
+		},
+	}})
+	hh.DisableCache()
+
+	req := httptest.NewRequest(http.MethodGet, "/self", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	req.Header.Set("Accept-Language", "zh;q=0.9, de;q=0.5")
+	rec := httptest.NewRecorder()
+
+	hh.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"name":"Frankreich"`) {
+		t.Fatalf("body = %s, want country.name = Frankreich", rec.Body.String())
+	}
+}
+
+// stubMetrics records every call made to it, so tests can assert on
+// ordering and arguments without pulling in a real metrics backend.
+type stubMetrics struct {
+	lookupDurations []time.Duration
+	lookupResults   []string
+	cacheResults    []bool
+	cacheSizes      [][2]int
+}
+
+func (m *stubMetrics) LookupDuration(d time.Duration) {
+	m.lookupDurations = append(m.lookupDurations, d)
+}
+func (m *stubMetrics) LookupResult(status string) { m.lookupResults = append(m.lookupResults, status) }
+func (m *stubMetrics) CacheResult(hit bool)       { m.cacheResults = append(m.cacheResults, hit) }
+func (m *stubMetrics) CacheSize(entries, bytes int) {
+	m.cacheSizes = append(m.cacheSizes, [2]int{entries, bytes})
+}
+
+type stubLogger struct {
+	entries []RequestLogEntry
+}
+
+func (l *stubLogger) LogRequest(entry RequestLogEntry) { l.entries = append(l.entries, entry) }
+
+func TestServeHTTPRecordsMetricsAndLogsOncePerRequest(t *testing.T) {
+	hh := NewHTTPHandler(stubLookuper{result: &LookupResult{Country: country{ISOCode: "US"}}})
+	hh.DisableCache()
+	metrics := &stubMetrics{}
+	logger := &stubLogger{}
+	hh.Metrics = metrics
+	hh.Logger = logger
+
+	req := httptest.NewRequest(http.MethodGet, "/self", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	rec := httptest.NewRecorder()
+
+	hh.ServeHTTP(rec, req)
+
+	if len(metrics.lookupResults) != 1 || metrics.lookupResults[0] != "ok" {
+		t.Fatalf("LookupResult calls = %v, want exactly one \"ok\"", metrics.lookupResults)
+	}
+	if len(metrics.lookupDurations) != 1 {
+		t.Fatalf("LookupDuration calls = %d, want exactly 1", len(metrics.lookupDurations))
+	}
+	if len(metrics.cacheResults) != 1 || metrics.cacheResults[0] != false {
+		t.Fatalf("CacheResult calls = %v, want exactly one miss", metrics.cacheResults)
+	}
+	if len(logger.entries) != 1 {
+		t.Fatalf("LogRequest calls = %d, want exactly 1", len(logger.entries))
+	}
+	if logger.entries[0].Status != http.StatusOK {
+		t.Fatalf("logged Status = %d, want 200", logger.entries[0].Status)
+	}
+}
+
+// TestServeHTTPRecordsCacheSize guards the bigcache size gauge: with
+// caching enabled, every request should report MemCache's current entry
+// count and byte usage alongside the hit/miss counter.
+func TestServeHTTPRecordsCacheSize(t *testing.T) {
+	hh := NewHTTPHandler(stubLookuper{result: &LookupResult{Country: country{ISOCode: "US"}}})
+	metrics := &stubMetrics{}
+	hh.Metrics = metrics
+
+	req := httptest.NewRequest(http.MethodGet, "/self", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	hh.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(metrics.cacheSizes) != 1 {
+		t.Fatalf("CacheSize calls = %d, want exactly 1", len(metrics.cacheSizes))
+	}
+	// CacheSize is reported at the point recordCache runs, which is before
+	// this (first, so a miss) response gets written into MemCache -- hence
+	// 0 entries here, not 1.
+	wantEntries, wantBytes := 0, hh.MemCache.Capacity()
+	if metrics.cacheSizes[0][0] != wantEntries || metrics.cacheSizes[0][1] != wantBytes {
+		t.Fatalf("CacheSize(%v), want (%d, %d)", metrics.cacheSizes[0], wantEntries, wantBytes)
+	}
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", s, err)
+	}
+	return n
+}
+
+func TestClientIPTrustedChainWalk(t *testing.T) {
+	hh := NewHTTPHandler(stubLookuper{result: &LookupResult{}})
+	hh.TrustedHeaders = []string{"X-Forwarded-For"}
+	hh.TrustedProxies = []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{
+			name:       "untrusted RemoteAddr ignores XFF",
+			remoteAddr: "203.0.113.5:1234",
+			xff:        "198.51.100.9",
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "trusted proxy defers to rightmost untrusted XFF entry",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "198.51.100.9, 10.0.0.2",
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "every XFF entry trusted falls back to the leftmost (original client end)",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "10.0.0.3, 10.0.0.2",
+			want:       "10.0.0.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/self", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.xff)
+
+			if got := hh.clientIP(req); got != tt.want {
+				t.Fatalf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
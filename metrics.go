@@ -0,0 +1,65 @@
+package geominder
+
+import (
+	"net/http"
+	"time"
+)
+
+// MetricsRecorder receives operational counters and histograms from
+// HTTPHandler without tinygeoip needing a hard dependency on any particular
+// metrics library. Wrap a *prometheus.Registry, an OpenTelemetry meter, or
+// anything else behind this interface and assign it to HTTPHandler.Metrics.
+type MetricsRecorder interface {
+	// LookupDuration records how long a single LookupDB.Lookup call took.
+	LookupDuration(d time.Duration)
+	// LookupResult records the outcome of a single lookup: "ok", "not_found"
+	// or "decode_error".
+	LookupResult(status string)
+	// CacheResult records a single cache lookup as a hit or a miss.
+	CacheResult(hit bool)
+	// CacheSize records MemCache's current entry count and the bytes it has
+	// allocated to hold them.
+	CacheSize(entries, bytes int)
+}
+
+// Logger receives one RequestLogEntry per HTTPHandler request. Assign a
+// Logger to HTTPHandler.Logger to get structured per-request logs without
+// tinygeoip depending on a particular logging library.
+type Logger interface {
+	LogRequest(entry RequestLogEntry)
+}
+
+// RequestLogEntry is a single structured log line for one HTTPHandler
+// request, passed to Logger.LogRequest.
+type RequestLogEntry struct {
+	// IP is the address the request resolved to, before any DB lookup.
+	IP string
+	// Status is the HTTP status code written to the response.
+	Status int
+	// Latency is the total time spent in ServeHTTP.
+	Latency time.Duration
+	// CacheHit reports whether the response was served from MemCache.
+	CacheHit bool
+}
+
+// statusWriter wraps an http.ResponseWriter to record the status code
+// eventually written, so ServeHTTP can log/record metrics on it after the
+// fact from a single deferred call.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.code = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// status returns the code passed to WriteHeader, or http.StatusOK if
+// WriteHeader was never called (as net/http itself assumes).
+func (sw *statusWriter) status() int {
+	if sw.code == 0 {
+		return http.StatusOK
+	}
+	return sw.code
+}
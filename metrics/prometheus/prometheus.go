@@ -0,0 +1,83 @@
+// Package prometheus adapts geominder.MetricsRecorder onto a
+// *prometheus.Registry, kept as a separate module-internal package so the
+// core geominder package doesn't carry a hard dependency on Prometheus.
+package prometheus
+
+import (
+	"time"
+
+	client "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rucas/tinygeoip"
+)
+
+var _ geominder.MetricsRecorder = (*Recorder)(nil)
+
+// Recorder implements geominder.MetricsRecorder on top of a set of
+// Prometheus collectors, all registered under the "geominder" namespace.
+type Recorder struct {
+	lookupDuration client.Histogram
+	lookupResults  *client.CounterVec
+	cacheResults   *client.CounterVec
+	cacheEntries   client.Gauge
+	cacheBytes     client.Gauge
+}
+
+// NewRecorder creates a Recorder and registers its collectors on reg.
+func NewRecorder(reg *client.Registry) *Recorder {
+	r := &Recorder{
+		lookupDuration: client.NewHistogram(client.HistogramOpts{
+			Namespace: "geominder",
+			Name:      "lookup_duration_seconds",
+			Help:      "Latency of LookupDB.Lookup calls.",
+			Buckets:   client.DefBuckets,
+		}),
+		lookupResults: client.NewCounterVec(client.CounterOpts{
+			Namespace: "geominder",
+			Name:      "lookup_results_total",
+			Help:      "Lookups by outcome: ok, not_found, decode_error.",
+		}, []string{"status"}),
+		cacheResults: client.NewCounterVec(client.CounterOpts{
+			Namespace: "geominder",
+			Name:      "cache_results_total",
+			Help:      "MemCache lookups by outcome: hit, miss.",
+		}, []string{"result"}),
+		cacheEntries: client.NewGauge(client.GaugeOpts{
+			Namespace: "geominder",
+			Name:      "cache_entries",
+			Help:      "Number of entries currently held in MemCache.",
+		}),
+		cacheBytes: client.NewGauge(client.GaugeOpts{
+			Namespace: "geominder",
+			Name:      "cache_bytes",
+			Help:      "Bytes currently allocated by MemCache to hold its entries.",
+		}),
+	}
+	reg.MustRegister(r.lookupDuration, r.lookupResults, r.cacheResults, r.cacheEntries, r.cacheBytes)
+	return r
+}
+
+// LookupDuration implements geominder.MetricsRecorder.
+func (r *Recorder) LookupDuration(d time.Duration) {
+	r.lookupDuration.Observe(d.Seconds())
+}
+
+// LookupResult implements geominder.MetricsRecorder.
+func (r *Recorder) LookupResult(status string) {
+	r.lookupResults.WithLabelValues(status).Inc()
+}
+
+// CacheResult implements geominder.MetricsRecorder.
+func (r *Recorder) CacheResult(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	r.cacheResults.WithLabelValues(result).Inc()
+}
+
+// CacheSize implements geominder.MetricsRecorder.
+func (r *Recorder) CacheSize(entries, bytes int) {
+	r.cacheEntries.Set(float64(entries))
+	r.cacheBytes.Set(float64(bytes))
+}
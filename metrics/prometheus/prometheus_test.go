@@ -0,0 +1,64 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	client "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gaugeValue(t *testing.T, g client.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, c client.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRecorderRecordsCounters(t *testing.T) {
+	r := NewRecorder(client.NewRegistry())
+
+	r.LookupDuration(5 * time.Millisecond)
+	r.LookupResult("ok")
+	r.LookupResult("ok")
+	r.CacheResult(true)
+	r.CacheResult(false)
+
+	if got := counterValue(t, r.lookupResults.WithLabelValues("ok")); got != 2 {
+		t.Errorf("lookup_results_total{status=ok} = %v, want 2", got)
+	}
+	if got := counterValue(t, r.cacheResults.WithLabelValues("hit")); got != 1 {
+		t.Errorf("cache_results_total{result=hit} = %v, want 1", got)
+	}
+	if got := counterValue(t, r.cacheResults.WithLabelValues("miss")); got != 1 {
+		t.Errorf("cache_results_total{result=miss} = %v, want 1", got)
+	}
+}
+
+func TestRecorderCacheSizeSetsGauges(t *testing.T) {
+	r := NewRecorder(client.NewRegistry())
+
+	r.CacheSize(3, 1024)
+	if got := gaugeValue(t, r.cacheEntries); got != 3 {
+		t.Errorf("cache_entries = %v, want 3", got)
+	}
+	if got := gaugeValue(t, r.cacheBytes); got != 1024 {
+		t.Errorf("cache_bytes = %v, want 1024", got)
+	}
+
+	r.CacheSize(0, 0)
+	if got := gaugeValue(t, r.cacheEntries); got != 0 {
+		t.Errorf("cache_entries after reset = %v, want 0", got)
+	}
+}
@@ -0,0 +1,96 @@
+package geominder
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// asn holds the autonomous system fields as decoded from a GeoLite2-ASN (or
+// GeoIP2-ISP) database. These live at the top level of that database's
+// records rather than nested under an "asn" key, so they're decoded
+// separately from LookupResult and only attached to it by MultiDB.
+type asn struct {
+	Number       uint   `maxminddb:"autonomous_system_number" json:"number,omitempty" xml:"number,omitempty"`
+	Organization string `maxminddb:"autonomous_system_organization" json:"organization,omitempty" xml:"organization,omitempty"`
+}
+
+// MultiDB composes several LookupDBs -- typically a GeoLite2-City (or
+// GeoLite2-Country) database alongside a GeoLite2-ASN database -- and merges
+// their results into a single LookupResult per lookup.
+//
+// Databases are told apart by their MaxMind DatabaseType metadata, so the
+// order dbs are passed in doesn't matter.
+type MultiDB struct {
+	asn    *LookupDB
+	others []*LookupDB
+}
+
+// NewMultiDB builds a MultiDB from already-open LookupDBs. At most one of
+// dbs may be an ASN-precision database; passing more than one is an error,
+// since there would be no principled way to choose between them.
+func NewMultiDB(dbs ...*LookupDB) (*MultiDB, error) {
+	m := &MultiDB{}
+	for _, db := range dbs {
+		if strings.Contains(db.databaseType(), "ASN") {
+			if m.asn != nil {
+				return nil, fmt.Errorf("geominder: NewMultiDB given more than one ASN database")
+			}
+			m.asn = db
+			continue
+		}
+		m.others = append(m.others, db)
+	}
+	return m, nil
+}
+
+// Lookup merges the results of looking ip up across every underlying
+// database: the first non-ASN database with a match supplies
+// Country/City/Location, and the ASN database (if any) supplies ASN.
+//
+// A failed ASN lookup is not fatal and simply leaves ASN nil; it's only an
+// error if every non-ASN database fails to find a match.
+func (m *MultiDB) Lookup(ip net.IP) (*LookupResult, error) {
+	var result *LookupResult
+	var lastErr error
+	for _, db := range m.others {
+		r, err := db.Lookup(ip)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result = r
+		break
+	}
+	if result == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no match for %v found in any database", ip)
+		}
+		return nil, lastErr
+	}
+
+	if m.asn != nil {
+		var a asn
+		if err := m.asn.decodeInto(ip, &a); err == nil {
+			result.ASN = &a
+		}
+	}
+	return result, nil
+}
+
+// Close closes every underlying database, returning the last error
+// encountered, if any.
+func (m *MultiDB) Close() error {
+	var err error
+	for _, db := range m.others {
+		if cerr := db.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	if m.asn != nil {
+		if cerr := m.asn.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package geominder
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMultiDBMergesCityAndASN(t *testing.T) {
+	city, err := NewLookupDB("testdata/city-v1.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(city): %v", err)
+	}
+	defer city.Close()
+	asnDB, err := NewLookupDB("testdata/asn.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(asn): %v", err)
+	}
+	defer asnDB.Close()
+
+	m, err := NewMultiDB(city, asnDB)
+	if err != nil {
+		t.Fatalf("NewMultiDB: %v", err)
+	}
+
+	r, err := m.Lookup(net.ParseIP("1.2.3.4"))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if r.Country.ISOCode != "US" {
+		t.Fatalf("Country.ISOCode = %q, want US", r.Country.ISOCode)
+	}
+	if r.ASN == nil || r.ASN.Number != 64500 || r.ASN.Organization != "Test ASN Org" {
+		t.Fatalf("ASN = %+v, want {64500 Test ASN Org}", r.ASN)
+	}
+}
+
+func TestMultiDBLeavesASNNilOnMiss(t *testing.T) {
+	city, err := NewLookupDB("testdata/city-v1.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(city): %v", err)
+	}
+	defer city.Close()
+	asnDB, err := NewLookupDB("testdata/asn.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(asn): %v", err)
+	}
+	defer asnDB.Close()
+
+	m, err := NewMultiDB(city, asnDB)
+	if err != nil {
+		t.Fatalf("NewMultiDB: %v", err)
+	}
+
+	// 9.9.9.9 isn't in either fixture's ASN database, but is in the same
+	// /0 the city fixture happens not to cover either -- both should miss,
+	// so the overall lookup fails rather than silently returning a
+	// half-populated result.
+	if _, err := m.Lookup(net.ParseIP("9.9.9.9")); err == nil {
+		t.Fatal("Lookup(9.9.9.9) = nil error, want a no-match error")
+	}
+}
+
+func TestNewMultiDBRejectsTwoASNDatabases(t *testing.T) {
+	asnA, err := NewLookupDB("testdata/asn.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(asn): %v", err)
+	}
+	defer asnA.Close()
+	asnB, err := NewLookupDB("testdata/asn.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB(asn): %v", err)
+	}
+	defer asnB.Close()
+
+	if _, err := NewMultiDB(asnA, asnB); err == nil {
+		t.Fatal("NewMultiDB(asn, asn) = nil error, want an error")
+	}
+}
@@ -0,0 +1,178 @@
+package geominder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DefaultWatchInterval is how often Watch stats the database file when no
+// interval is given explicitly.
+const DefaultWatchInterval = 30 * time.Second
+
+// refReader pairs a *maxminddb.Reader with a reference count, so reload can
+// close the reader it just swapped out only once every Lookup/FastLookup
+// call that grabbed it beforehand has finished with it -- rather than
+// guessing at how long that might take with a fixed grace period.
+//
+// refs starts at 1, representing the reference LookupDB.reader itself
+// holds; reload drops that one via release when it swaps in a new
+// refReader, and each decodeInto call acquires/releases its own around the
+// lookup it does. The last release to bring refs to zero closes reader.
+type refReader struct {
+	reader *maxminddb.Reader
+	refs   atomic.Int64
+}
+
+func newRefReader(r *maxminddb.Reader) *refReader {
+	rr := &refReader{reader: r}
+	rr.refs.Store(1)
+	return rr
+}
+
+// acquire adds an in-flight reference, returning false if reader has
+// already been fully released (and closed) instead. A false return means
+// the caller raced a reload that retired this refReader right as it was
+// loaded; it should simply re-load LookupDB.reader and try again, since a
+// retired refReader is only ever observable after its replacement has
+// already been stored.
+func (rr *refReader) acquire() bool {
+	for {
+		cur := rr.refs.Load()
+		if cur <= 0 {
+			return false
+		}
+		if rr.refs.CompareAndSwap(cur, cur+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference, closing reader once the count reaches zero.
+func (rr *refReader) release() error {
+	if rr.refs.Add(-1) == 0 {
+		return rr.reader.Close()
+	}
+	return nil
+}
+
+// LookupDBOption configures optional behavior on NewLookupDB.
+type LookupDBOption func(*LookupDB)
+
+// WatchPath overrides the path that Watch stats and reopens from, for
+// deployments where the path NewLookupDB was opened with isn't the one that
+// gets updated in place (e.g. a symlink swap next to it).
+func WatchPath(path string) LookupDBOption {
+	return func(l *LookupDB) { l.watchPath = path }
+}
+
+// Reloadable is implemented by databases that support hot-reloading (see
+// LookupDB.Watch). HTTPHandler uses it to flush its cache whenever the
+// underlying database is swapped out from under it.
+type Reloadable interface {
+	OnReload(fn func())
+}
+
+// OnReload registers fn to run after every successful hot-reload triggered
+// by Watch. Implements Reloadable.
+func (l *LookupDB) OnReload(fn func()) {
+	l.mu.Lock()
+	l.onReload = append(l.onReload, fn)
+	l.mu.Unlock()
+}
+
+// Watch polls the database file at DefaultWatchInterval and hot-reloads it
+// whenever its mtime or size changes, until ctx is done. See WatchInterval
+// to use a different poll interval.
+func (l *LookupDB) Watch(ctx context.Context) error {
+	return l.WatchInterval(ctx, DefaultWatchInterval)
+}
+
+// WatchInterval is Watch with an explicit poll interval, letting long-running
+// servers pick up MaxMind's weekly GeoLite2 updates without a restart.
+//
+// Reopening is atomic from the perspective of Lookup/FastLookup callers:
+// they keep using the old reader until it's done serving in-flight lookups,
+// at which point it's closed.
+func (l *LookupDB) WatchInterval(ctx context.Context, interval time.Duration) error {
+	path := l.watchPath
+	if path == "" {
+		path = l.dbPath
+	}
+	if path == "" {
+		return fmt.Errorf("geominder: Watch requires a database path, but LookupDB wasn't opened with one")
+	}
+
+	stamp, err := statStamp(path)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s, err := statStamp(path)
+			if err != nil {
+				// Transient failure, e.g. the file is mid-replace; try
+				// again next tick rather than giving up on the watch.
+				continue
+			}
+			if s == stamp {
+				continue
+			}
+			if err := l.reload(path); err != nil {
+				continue
+			}
+			stamp = s
+			l.runReloadHooks()
+		}
+	}
+}
+
+// reload opens a fresh reader for path and atomically swaps it in place of
+// the current one. The old refReader is released immediately, but its
+// underlying reader isn't actually Close()d until every Lookup/FastLookup
+// call already in flight against it finishes; see refReader.
+func (l *LookupDB) reload(path string) error {
+	newReader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	old := l.reader.Swap(newRefReader(newReader))
+	if old != nil {
+		_ = old.release() // nothing actionable to do with a close error here
+	}
+	return nil
+}
+
+func (l *LookupDB) runReloadHooks() {
+	l.mu.Lock()
+	hooks := append([]func(){}, l.onReload...)
+	l.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// fileStamp identifies a version of a file on disk well enough to detect
+// MaxMind's typical update-in-place: a new mtime and/or a changed size.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statStamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
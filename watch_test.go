@@ -0,0 +1,165 @@
+package geominder
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func copyFile(t *testing.T, dst, src string) {
+	t.Helper()
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("open %s: %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("create %s: %v", dst, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatalf("copy %s -> %s: %v", src, dst, err)
+	}
+}
+
+func TestWatchIntervalHotReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	copyFile(t, path, "testdata/city-v1.mmdb")
+
+	db, err := NewLookupDB(path)
+	if err != nil {
+		t.Fatalf("NewLookupDB: %v", err)
+	}
+	defer db.Close()
+
+	reloaded := make(chan struct{}, 1)
+	db.OnReload(func() { reloaded <- struct{}{} })
+
+	ip := net.ParseIP("1.2.3.4")
+	r, err := db.Lookup(ip)
+	if err != nil {
+		t.Fatalf("Lookup before reload: %v", err)
+	}
+	if r.Country.ISOCode != "US" {
+		t.Fatalf("Country.ISOCode before reload = %q, want US", r.Country.ISOCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.WatchInterval(ctx, 10*time.Millisecond)
+
+	// Give WatchInterval's goroutine time to take its initial stat before
+	// the file changes underneath it, so the change is actually observed
+	// as a change rather than baked into the starting stamp.
+	time.Sleep(50 * time.Millisecond)
+	copyFile(t, path, "testdata/city-v2.mmdb")
+
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnReload hook never fired")
+	}
+
+	r, err = db.Lookup(ip)
+	if err != nil {
+		t.Fatalf("Lookup after reload: %v", err)
+	}
+	if r.Country.ISOCode != "FR" {
+		t.Fatalf("Country.ISOCode after reload = %q, want FR", r.Country.ISOCode)
+	}
+}
+
+func TestWatchIntervalRequiresAPath(t *testing.T) {
+	db := &LookupDB{}
+	if err := db.WatchInterval(context.Background(), time.Second); err == nil {
+		t.Fatal("WatchInterval on a path-less LookupDB = nil error, want an error")
+	}
+}
+
+// TestReloadKeepsOldReaderAliveForInFlightLookups simulates a slow
+// Lookup/FastLookup that's already holding the old reader when reload
+// swaps in a new one: decodeInto's acquire() call is stood in for
+// directly, so the held reference outlives the reload itself, and the old
+// reader must still decode correctly afterwards instead of having been
+// closed out from under it.
+func TestReloadKeepsOldReaderAliveForInFlightLookups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "city.mmdb")
+	copyFile(t, path, "testdata/city-v1.mmdb")
+
+	db, err := NewLookupDB(path)
+	if err != nil {
+		t.Fatalf("NewLookupDB: %v", err)
+	}
+	defer db.Close()
+
+	oldRR := db.reader.Load()
+	if !oldRR.acquire() {
+		t.Fatal("acquire() on a freshly-opened reader returned false")
+	}
+
+	// Atomically replace the database file via rename, as a real deployment
+	// would, rather than overwriting it in place: the replacement gets its
+	// own inode, so the OLD reader's already-mapped pages keep showing the
+	// pre-reload content instead of observing the swap out from under it.
+	replacement := filepath.Join(dir, "city.mmdb.new")
+	copyFile(t, replacement, "testdata/city-v2.mmdb")
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := db.reload(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	// The old reader must still work: our acquire() above is standing in
+	// for an in-flight Lookup that started before the reload.
+	ip := net.ParseIP("1.2.3.4")
+	offset, err := oldRR.reader.LookupOffset(ip)
+	if err != nil {
+		t.Fatalf("LookupOffset on old reader after reload: %v", err)
+	}
+	var r LookupResult
+	if err := oldRR.reader.Decode(offset, &r); err != nil {
+		t.Fatalf("Decode on old reader after reload: %v", err)
+	}
+	if r.Country.ISOCode != "US" {
+		t.Fatalf("old reader decoded %+v, want the pre-reload US record", r.Country)
+	}
+
+	// Releasing our stand-in reference should now actually close it.
+	if err := oldRR.release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, err := oldRR.reader.LookupOffset(ip); err == nil {
+		t.Fatal("LookupOffset on old reader after its last reference released = nil error, want a closed-database error")
+	}
+
+	// And the new reader, meanwhile, already reflects the reload.
+	r2, err := db.Lookup(ip)
+	if err != nil {
+		t.Fatalf("Lookup after reload: %v", err)
+	}
+	if r2.Country.ISOCode != "FR" {
+		t.Fatalf("Lookup after reload = %+v, want FR", r2.Country)
+	}
+}
+
+func TestRefReaderAcquireFailsAfterRefsReachZero(t *testing.T) {
+	db, err := NewLookupDB("testdata/city-v1.mmdb")
+	if err != nil {
+		t.Fatalf("NewLookupDB: %v", err)
+	}
+	rr := db.reader.Load()
+
+	if err := rr.release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if rr.acquire() {
+		t.Fatal("acquire() on a fully-released refReader = true, want false")
+	}
+}